@@ -3,16 +3,14 @@ package config
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
-	"log"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"text/template"
 	"time"
 
 	common "github.com/ncabatoff/process-exporter"
-	"gopkg.in/yaml.v2"
 )
 
 type (
@@ -38,14 +36,36 @@ type (
 	}
 
 	cmdlineMatcher struct {
-		regexes  []*regexp.Regexp
-		captures map[string]string
+		regexes []*regexp.Regexp
+	}
+
+	usernameMatcher struct {
+		usernames map[string]struct{}
+	}
+
+	cgroupMatcher struct {
+		regexes []*regexp.Regexp
+	}
+
+	envMatcher struct {
+		regexes map[string]*regexp.Regexp
+	}
+
+	ppidCommMatcher struct {
+		comms map[string]struct{}
 	}
 
 	andMatcher []Matcher
 
+	orMatcher []Matcher
+
+	notMatcher struct {
+		matcher Matcher
+	}
+
 	templateNamer struct {
 		template *template.Template
+		raw      string
 	}
 
 	matchNamer struct {
@@ -61,9 +81,21 @@ type (
 		PID       int
 		StartTime time.Time
 		Matches   map[string]string
+		Cgroup    string
+		Env       map[string]string
 	}
 )
 
+// captureState accumulates data gathered while evaluating an andMatcher's
+// submatchers that templateParams needs beyond a plain true/false: named
+// regex captures (from cmdline/cgroup matchers), the raw cgroup file
+// content, and matched environment variable values.
+type captureState struct {
+	matches map[string]string
+	cgroup  string
+	env     map[string]string
+}
+
 func (c *cmdlineMatcher) String() string {
 	return fmt.Sprintf("cmdlines: %+v", c.regexes)
 
@@ -78,6 +110,7 @@ func (c *commMatcher) String() string {
 	for cm := range c.comms {
 		comms = append(comms, cm)
 	}
+	sort.Strings(comms)
 	return fmt.Sprintf("comms: %+v", comms)
 }
 
@@ -94,24 +127,19 @@ func (f FirstMatcher) MatchAndName(nacl common.ProcAttributes) (bool, string) {
 	return false, ""
 }
 
+// String reports both the matcher tree and the raw name: template, so
+// HasChanged (config/watcher.go) also catches a reload that only changes how
+// matched processes are named.
 func (m *matchNamer) String() string {
-	return fmt.Sprintf("%+v", m.andMatcher)
+	return fmt.Sprintf("%+v name:%q", m.andMatcher, m.raw)
 }
 
 func (m *matchNamer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
-	if !m.Match(nacl) {
+	state := &captureState{matches: make(map[string]string)}
+	if !matchWithCaptures(m.andMatcher, nacl, state) {
 		return false, ""
 	}
 
-	matches := make(map[string]string)
-	for _, m := range m.andMatcher {
-		if mc, ok := m.(*cmdlineMatcher); ok {
-			for k, v := range mc.captures {
-				matches[k] = v
-			}
-		}
-	}
-
 	exebase, exefull := nacl.Name, nacl.Name
 	if len(nacl.Cmdline) > 0 {
 		exefull = nacl.Cmdline[0]
@@ -123,7 +151,9 @@ func (m *matchNamer) MatchAndName(nacl common.ProcAttributes) (bool, string) {
 		Comm:      nacl.Name,
 		ExeBase:   exebase,
 		ExeFull:   exefull,
-		Matches:   matches,
+		Matches:   state.matches,
+		Cgroup:    state.cgroup,
+		Env:       state.env,
 		Username:  nacl.Username,
 		PID:       nacl.PID,
 		StartTime: nacl.StartTime,
@@ -152,19 +182,32 @@ func (m *exeMatcher) Match(nacl common.ProcAttributes) bool {
 	return fqpath == nacl.Cmdline[0]
 }
 
+// Match reports whether every compiled regex matches nacl's cmdline. The
+// regex list itself is never mutated, so this (and matchCaptures below) is
+// safe to call concurrently for different procs against the same
+// cmdlineMatcher.
 func (m *cmdlineMatcher) Match(nacl common.ProcAttributes) bool {
+	return m.matchCaptures(nacl, nil)
+}
+
+// matchCaptures is like Match, but if state is non-nil its matches map is
+// filled with the named subexpressions of each matching regex. Each call
+// gets its own state rather than mutating anything shared on m.
+func (m *cmdlineMatcher) matchCaptures(nacl common.ProcAttributes, state *captureState) bool {
+	cmdline := strings.Join(nacl.Cmdline, " ")
 	for _, regex := range m.regexes {
-		captures := regex.FindStringSubmatch(strings.Join(nacl.Cmdline, " "))
-		if m.captures == nil {
+		found := regex.FindStringSubmatch(cmdline)
+		if found == nil {
 			return false
 		}
-		subexpNames := regex.SubexpNames()
-		if len(subexpNames) != len(captures) {
-			return false
+		if state == nil {
+			continue
 		}
-
-		for i, name := range subexpNames {
-			m.captures[name] = captures[i]
+		for i, name := range regex.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			state.matches[name] = found[i]
 		}
 	}
 	return true
@@ -179,228 +222,280 @@ func (m andMatcher) Match(nacl common.ProcAttributes) bool {
 	return true
 }
 
-// getProcessNames extracts teh anmes of the processes from the given procname
-func getProcessNames(procname interface{}) []string {
-	nm, ok := procname.(map[interface{}]interface{})
-	if !ok {
-		return nil
+func (m orMatcher) Match(nacl common.ProcAttributes) bool {
+	for _, matcher := range m {
+		if matcher.Match(nacl) {
+			return true
+		}
 	}
+	return false
+}
 
-	var names []string
-	// 检测report_missing字段是否出现
-	var reportMissingFlag int
+func (m notMatcher) Match(nacl common.ProcAttributes) bool {
+	return !m.matcher.Match(nacl)
+}
 
-	// 检查report_missing字段，不存在则返回，默认不启用
-	for k, v := range nm {
-		key, ok := k.(string)
-		if !ok {
-			return nil
-		}
-		if key == "report_missing" {
-			reportMissingFlag = 1
-			value, ok := v.(bool)
-			if !ok {
-				return nil
+// matchWithCaptures evaluates m against nacl like Match, but recurses into
+// the and/or/not boolean tree so that capture-producing leaves (cmdline,
+// cgroup, env) can fill state no matter how deep they sit in it. A negated
+// subtree's captures are meaningless since it didn't match, so it's
+// evaluated without capturing.
+func matchWithCaptures(m Matcher, nacl common.ProcAttributes, state *captureState) bool {
+	switch mt := m.(type) {
+	case andMatcher:
+		for _, sub := range mt {
+			if !matchWithCaptures(sub, nacl, state) {
+				return false
 			}
-			// report_missing为true，跳出循环
-			if value {
-				break
+		}
+		return true
+	case orMatcher:
+		for _, sub := range mt {
+			if matchWithCaptures(sub, nacl, state) {
+				return true
 			}
 		}
-
+		return false
+	case notMatcher:
+		return !matchWithCaptures(mt.matcher, nacl, nil)
+	case *cmdlineMatcher:
+		return mt.matchCaptures(nacl, state)
+	case *cgroupMatcher:
+		return mt.matchCaptures(nacl, state)
+	case *envMatcher:
+		return mt.matchCaptures(nacl, state)
+	default:
+		return m.Match(nacl)
 	}
-	// 没有report_missing字段则返回
-	if reportMissingFlag == 0 {
+}
+
+// getProcessNames extracts the names of the processes covered by entry, for
+// reporting on configured-but-missing processes. It only returns anything
+// if report_missing is present at all (regardless of its value, matching
+// this field's long-standing, slightly quirky semantics): a 'name' template
+// on its own, else the literal comm names plus exe basenames.
+func getProcessNames(entry rawProcessName) []string {
+	if entry.ReportMissing == nil {
 		return nil
 	}
-	//check for 'name' field. If contains name field other fields are not extracted
-	for k, v := range nm {
-		key, ok := k.(string)
-		if !ok {
-			return nil
-		}
-		if key == "name" {
-			value, ok := v.(string)
-			if !ok {
-				return nil
-			}
-			names = append(names, value)
-			return names
-		}
+	if entry.Name != "" {
+		return []string{entry.Name}
 	}
 
-	for k, v := range nm {
-		key, ok := k.(string)
-		if !ok {
-			return nil
-		}
-
-		if key == "comm" {
-			// "comm" block in config file - extract values as is from array
-			values, ok := v.([]interface{})
-			if !ok {
-				return nil
-			}
-			for _, rawValue := range values {
-				value, ok := rawValue.(string)
-				if !ok {
-					return nil
-				}
-				names = append(names, value)
-			}
-		} else if key == "exe" {
-			// "exe" block in config file - extracts names from array
-			exes, ok := v.([]interface{})
-			if !ok {
-				return nil
-			}
-			for _, rawValue := range exes {
-				value, ok := rawValue.(string)
-				if !ok {
-					return nil
-				}
-				// check for forward slash - need to extract filename if "/" is present
-				if strings.Contains(value, "/") {
-					names = append(names, filepath.Base(value))
-				} else {
-					names = append(names, value)
-				}
-			}
+	var names []string
+	names = append(names, entry.Comm...)
+	for _, e := range entry.Exe {
+		if strings.Contains(e, "/") {
+			names = append(names, filepath.Base(e))
+		} else {
+			names = append(names, e)
 		}
 	}
 	return names
 }
 
-// ReadRecipesFile opens the named file and extracts recipes from it.
-func ReadFile(cfgpath string, debug bool) (*Config, *[]string,error) {
-	content, err := ioutil.ReadFile(cfgpath)
+// ReadFile opens the named file and extracts recipes from it. If
+// cfgpath names a directory instead, all its YAML fragments are loaded and
+// merged instead; see ReadDir.
+func ReadFile(cfgpath string, debug bool) (*Config, *[]string, error) {
+	cfg, err := loadConfig(cfgpath, debug)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error reading config file %q: %v", cfgpath, err)
-	}
-	if debug {
-		log.Printf("Config file %q contents:\n%s", cfgpath, content)
+		return nil, nil, err
 	}
-	return GetConfig(string(content), debug)
+	return configFromRaw(cfg, debug)
 }
 
 // GetConfig extracts Config from content by parsing it as YAML.
 func GetConfig(content string, debug bool) (*Config, *[]string, error) {
-	var yamldata map[string]interface{}
-
-	err := yaml.Unmarshal([]byte(content), &yamldata)
+	cfg, err := decodeConfig("<config>", content)
 	if err != nil {
 		return nil, nil, err
 	}
-	yamlProcnames, ok := yamldata["process_names"]
-	if !ok {
+	return configFromRaw(cfg, debug)
+}
+
+// configFromRaw builds a Config from the typed, already-merged rawConfig.
+func configFromRaw(raw *rawConfig, debug bool) (*Config, *[]string, error) {
+	if len(raw.ProcessNames) == 0 {
 		return nil, nil, fmt.Errorf("error parsing YAML config: no top-level 'process_names' key")
 	}
-	procnames, ok := yamlProcnames.([]interface{})
-	if !ok {
-		return nil, nil, fmt.Errorf("error parsing YAML config: 'process_names' is not a list")
-	}
 
 	var cfg Config
 	var processNames []string
-	for i, procname := range procnames {
-		mn, err := getMatchNamer(procname)
+	named := make(map[string]Matcher)
+	for i, entry := range raw.ProcessNames {
+		mn, id, err := getMatchNamer(entry, named)
 		if err != nil {
 			return nil, nil, fmt.Errorf("unable to parse process_name entry %d: %v", i, err)
 		}
 		cfg.MatchNamers.matchers = append(cfg.MatchNamers.matchers, mn)
+		if id != "" {
+			if mnWithMatcher, ok := mn.(*matchNamer); ok {
+				named[id] = mnWithMatcher.andMatcher
+			}
+		}
 
-		// get names of all processes
-		pNames := getProcessNames(procname)
-		processNames = append(processNames, pNames...)
+		processNames = append(processNames, getProcessNames(entry)...)
 	}
 
 	return &cfg, &processNames, nil
 }
 
-func getMatchNamer(yamlmn interface{}) (common.MatchNamer, error) {
-	nm, ok := yamlmn.(map[interface{}]interface{})
-	if !ok {
-		return nil, fmt.Errorf("not a map")
+// getMatchNamer parses one process_names entry into a MatchNamer, plus the
+// id it declares (if any) so the caller can make it available to later
+// entries' include: lists via named.
+func getMatchNamer(entry rawProcessName, named map[string]Matcher) (common.MatchNamer, string, error) {
+	matchers, err := buildMatcherTree(entry.rawMatcherNode, named)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(matchers) == 0 {
+		return nil, "", fmt.Errorf("no matchers provided")
 	}
 
-	var smap = make(map[string][]string)
-	var nametmpl string
-	for k, v := range nm {
-		key, ok := k.(string)
-		if !ok {
-			return nil, fmt.Errorf("non-string key %v", k)
-		}
-
-		if key == "name" {
-			value, ok := v.(string)
-			if !ok {
-				return nil, fmt.Errorf("non-string value %v for key %q", v, key)
-			}
-			nametmpl = value
-		} else if key == "report_missing" {
-			continue
-		} else {
-			vals, ok := v.([]interface{})
-			if !ok {
-				return nil, fmt.Errorf("non-string array value %v for key %q", v, key)
-			}
-			var strs []string
-			for i, si := range vals {
-				s, ok := si.(string)
-				if !ok {
-					return nil, fmt.Errorf("non-string value %v in list[%d] for key %q", v, i, key)
-				}
-				strs = append(strs, s)
-			}
-			smap[key] = strs
-		}
+	nametmpl := entry.Name
+	if nametmpl == "" {
+		nametmpl = "{{.ExeBase}}"
 	}
+	tmpl, err := template.New("cmdname").Parse(nametmpl)
+	if err != nil {
+		return nil, "", fmt.Errorf("bad name template %q: %v", nametmpl, err)
+	}
+
+	return &matchNamer{matchers, templateNamer{tmpl, nametmpl}}, entry.ID, nil
+}
+
+// buildMatcherTree turns the matcher keys of a process_names entry, or of a
+// nested all:/any:/not: node, into the Matchers it specifies, ANDed
+// together. named provides already-declared top-level matchers reachable
+// via include:.
+func buildMatcherTree(node rawMatcherNode, named map[string]Matcher) (andMatcher, error) {
+	var tree andMatcher
 
-	var matchers andMatcher
-	if comm, ok := smap["comm"]; ok {
-		comms := make(map[string]struct{})
-		for _, c := range comm {
+	if len(node.Comm) > 0 {
+		comms := make(map[string]struct{}, len(node.Comm))
+		for _, c := range node.Comm {
 			comms[c] = struct{}{}
 		}
-		matchers = append(matchers, &commMatcher{comms})
+		tree = append(tree, &commMatcher{comms})
 	}
-	if exe, ok := smap["exe"]; ok {
-		exes := make(map[string]string)
-		for _, e := range exe {
+
+	if len(node.Exe) > 0 {
+		exes := make(map[string]string, len(node.Exe))
+		for _, e := range node.Exe {
 			if strings.Contains(e, "/") {
 				exes[filepath.Base(e)] = e
 			} else {
 				exes[e] = ""
 			}
 		}
-		matchers = append(matchers, &exeMatcher{exes})
+		tree = append(tree, &exeMatcher{exes})
+	}
+
+	if len(node.Cmdline) > 0 {
+		posix := node.CmdlineSyntax == "posix"
+		compile := regexp.Compile
+		if posix {
+			// POSIX leftmost-longest semantics, as admins coming from
+			// grep/awk expect. Note RE2's (?P<name>...) named capture
+			// syntax isn't part of POSIX ERE and CompilePOSIX rejects it.
+			compile = regexp.CompilePOSIX
+		} else if node.CmdlineSyntax != "" && node.CmdlineSyntax != "re2" {
+			return nil, fmt.Errorf("unknown cmdline_syntax %q, want re2 or posix", node.CmdlineSyntax)
+		}
+		var rs []*regexp.Regexp
+		for _, c := range node.Cmdline {
+			r, err := compile(c)
+			if err != nil {
+				if posix && strings.Contains(c, "(?P<") {
+					return nil, fmt.Errorf("bad cmdline regex %q: named capture groups aren't supported under cmdline_syntax: posix: %v", c, err)
+				}
+				return nil, fmt.Errorf("bad cmdline regex %q: %v", c, err)
+			}
+			rs = append(rs, r)
+		}
+		tree = append(tree, &cmdlineMatcher{regexes: rs})
 	}
-	if cmdline, ok := smap["cmdline"]; ok {
+
+	if len(node.Username) > 0 {
+		usernames := make(map[string]struct{}, len(node.Username))
+		for _, u := range node.Username {
+			usernames[u] = struct{}{}
+		}
+		tree = append(tree, &usernameMatcher{usernames})
+	}
+
+	if len(node.Cgroup) > 0 {
 		var rs []*regexp.Regexp
-		for _, c := range cmdline {
+		for _, c := range node.Cgroup {
 			r, err := regexp.Compile(c)
 			if err != nil {
-				return nil, fmt.Errorf("bad cmdline regex %q: %v", c, err)
+				return nil, fmt.Errorf("bad cgroup regex %q: %v", c, err)
 			}
 			rs = append(rs, r)
 		}
-		matchers = append(matchers, &cmdlineMatcher{
-			regexes:  rs,
-			captures: make(map[string]string),
-		})
+		tree = append(tree, &cgroupMatcher{regexes: rs})
 	}
-	if len(matchers) == 0 {
-		return nil, fmt.Errorf("no matchers provided")
+
+	if len(node.Env) > 0 {
+		regexes := make(map[string]*regexp.Regexp, len(node.Env))
+		for name, pattern := range node.Env {
+			r, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("bad env regex %q for var %q: %v", pattern, name, err)
+			}
+			regexes[name] = r
+		}
+		tree = append(tree, &envMatcher{regexes: regexes})
 	}
 
-	if nametmpl == "" {
-		nametmpl = "{{.ExeBase}}"
+	if len(node.PPIDComm) > 0 {
+		comms := make(map[string]struct{}, len(node.PPIDComm))
+		for _, c := range node.PPIDComm {
+			comms[c] = struct{}{}
+		}
+		tree = append(tree, &ppidCommMatcher{comms})
 	}
-	tmpl := template.New("cmdname")
-	tmpl, err := tmpl.Parse(nametmpl)
-	if err != nil {
-		return nil, fmt.Errorf("bad name template %q: %v", nametmpl, err)
+
+	if len(node.All) > 0 {
+		subs := make([]Matcher, 0, len(node.All))
+		for i, sub := range node.All {
+			m, err := buildMatcherTree(sub, named)
+			if err != nil {
+				return nil, fmt.Errorf("bad all[%d]: %v", i, err)
+			}
+			subs = append(subs, m)
+		}
+		tree = append(tree, andMatcher(subs))
+	}
+
+	if len(node.Any) > 0 {
+		subs := make([]Matcher, 0, len(node.Any))
+		for i, sub := range node.Any {
+			m, err := buildMatcherTree(sub, named)
+			if err != nil {
+				return nil, fmt.Errorf("bad any[%d]: %v", i, err)
+			}
+			subs = append(subs, m)
+		}
+		tree = append(tree, orMatcher(subs))
+	}
+
+	if node.Not != nil {
+		m, err := buildMatcherTree(*node.Not, named)
+		if err != nil {
+			return nil, fmt.Errorf("bad not: %v", err)
+		}
+		tree = append(tree, notMatcher{m})
+	}
+
+	for _, id := range node.Include {
+		inc, ok := named[id]
+		if !ok {
+			return nil, fmt.Errorf("include references unknown id %q (must be declared by an earlier entry)", id)
+		}
+		tree = append(tree, inc)
 	}
 
-	return &matchNamer{matchers, templateNamer{tmpl}}, nil
+	return tree, nil
 }
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+	"testing"
+	"text/template"
+
+	common "github.com/ncabatoff/process-exporter"
+)
+
+// TestCmdlineMatcherConcurrent exercises MatchAndName from many goroutines
+// against one shared cmdlineMatcher, as happens in production where a single
+// Config is read concurrently by every scraped proc. Each goroutine's named
+// capture must come back as its own, not another goroutine's: run with
+// `go test -race` to catch the captures map being shared and mutated
+// in place rather than allocated fresh per call.
+func TestCmdlineMatcherConcurrent(t *testing.T) {
+	mn := &matchNamer{
+		andMatcher{&cmdlineMatcher{regexes: []*regexp.Regexp{
+			regexp.MustCompile(`^worker (?P<id>\d+)$`),
+		}}},
+		templateNamer{template.Must(template.New("test").Parse("{{.Matches.id}}")), "{{.Matches.id}}"},
+	}
+
+	const n = 64
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			defer wg.Done()
+			nacl := common.ProcAttributes{
+				Name:    "worker",
+				Cmdline: []string{fmt.Sprintf("worker %d", i)},
+			}
+			matched, name := mn.MatchAndName(nacl)
+			if !matched {
+				t.Errorf("proc %d: expected match", i)
+				return
+			}
+			want := fmt.Sprintf("%d", i)
+			if name != want {
+				t.Errorf("proc %d: got name %q, want %q", i, name, want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// TestBuildMatcherTreeComposition exercises buildMatcherTree's any/all/not
+// handling directly, at the Matcher-tree level, so the asserted behavior
+// isn't entangled with FirstMatcher's first-match-wins precedence.
+func TestBuildMatcherTreeComposition(t *testing.T) {
+	node := rawMatcherNode{
+		Any: []rawMatcherNode{
+			{Comm: []string{"nginx"}},
+			{
+				All: []rawMatcherNode{
+					{Comm: []string{"worker"}, Username: []string{"www-data"}},
+				},
+			},
+		},
+	}
+	tree, err := buildMatcherTree(node, nil)
+	if err != nil {
+		t.Fatalf("buildMatcherTree: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		nacl common.ProcAttributes
+		want bool
+	}{
+		{"nginx matches the any", common.ProcAttributes{Name: "nginx"}, true},
+		{"worker as www-data matches the nested all", common.ProcAttributes{Name: "worker", Username: "www-data"}, true},
+		{"worker as root fails the nested all", common.ProcAttributes{Name: "worker", Username: "root"}, false},
+		{"unrelated comm matches neither branch", common.ProcAttributes{Name: "sshd"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := tree.Match(c.nacl); got != c.want {
+				t.Fatalf("Match = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+// TestBuildMatcherTreeInclude exercises the id-based include: mechanism:
+// an entry that includes a previously declared id ANDs that id's whole
+// matcher tree onto its own.
+func TestBuildMatcherTreeInclude(t *testing.T) {
+	named := map[string]Matcher{
+		"deploys": andMatcher{&usernameMatcher{usernames: map[string]struct{}{"deploy": {}}}},
+	}
+	tree, err := buildMatcherTree(rawMatcherNode{Comm: []string{"other"}, Include: []string{"deploys"}}, named)
+	if err != nil {
+		t.Fatalf("buildMatcherTree: %v", err)
+	}
+
+	cases := []struct {
+		name string
+		nacl common.ProcAttributes
+		want bool
+	}{
+		{"matches own comm and included username", common.ProcAttributes{Name: "other", Username: "deploy"}, true},
+		{"matches own comm but fails included username", common.ProcAttributes{Name: "other", Username: "root"}, false},
+		{"matches included username but fails own comm", common.ProcAttributes{Name: "nginx", Username: "deploy"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := tree.Match(c.nacl); got != c.want {
+				t.Fatalf("Match = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestIncludeUnknownID(t *testing.T) {
+	yamlCfg := `
+process_names:
+  - comm: [sshd]
+    include: [nope]
+`
+	_, _, err := GetConfig(yamlCfg, false)
+	if err == nil {
+		t.Fatal("expected error for include of undeclared id")
+	}
+}
+
+func TestUsernameMatcher(t *testing.T) {
+	m := &usernameMatcher{usernames: map[string]struct{}{"alice": {}}}
+	if !m.Match(common.ProcAttributes{Username: "alice"}) {
+		t.Error("expected match for alice")
+	}
+	if m.Match(common.ProcAttributes{Username: "bob"}) {
+		t.Error("expected no match for bob")
+	}
+}
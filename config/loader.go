@@ -0,0 +1,151 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// envOverlayVar names the environment variable used to select a
+// per-environment overlay file alongside a single config file, e.g.
+// PROCESS_EXPORTER_ENV=production with -config.path processes.yml will pull
+// in processes.production.yml if present.
+const envOverlayVar = "PROCESS_EXPORTER_ENV"
+
+// loadConfig resolves cfgpath to a single, merged rawConfig. If cfgpath is
+// a directory, every *.yml/*.yaml fragment inside it is read in lexical
+// order and merged in turn (see ReadDir). If cfgpath is a regular file, it
+// is read as-is and then, if envOverlayVar is set and a matching overlay
+// file exists next to it, the overlay is merged on top.
+func loadConfig(cfgpath string, debug bool) (*rawConfig, error) {
+	info, err := os.Stat(cfgpath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config path %q: %v", cfgpath, err)
+	}
+
+	if info.IsDir() {
+		return ReadDir(cfgpath, debug)
+	}
+
+	merged, err := readConfigFile(cfgpath, debug)
+	if err != nil {
+		return nil, err
+	}
+
+	overlay, ok := overlayPath(cfgpath)
+	if !ok {
+		return merged, nil
+	}
+	if _, err := os.Stat(overlay); err != nil {
+		if os.IsNotExist(err) {
+			return merged, nil
+		}
+		return nil, fmt.Errorf("error checking overlay config %q: %v", overlay, err)
+	}
+
+	overlaycfg, err := readConfigFile(overlay, debug)
+	if err != nil {
+		return nil, err
+	}
+	return mergeConfigs(merged, overlaycfg), nil
+}
+
+// ReadDir loads every *.yml/*.yaml fragment in dir, in lexical order, and
+// merges them into a single rawConfig. Later fragments take priority: a
+// process_names entry in a later fragment replaces an earlier one with the
+// same `id:`, while entries without an id (or with a new id) are appended.
+func ReadDir(dir string, debug bool) (*rawConfig, error) {
+	files, err := fragmentFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no YAML fragments found in %q", dir)
+	}
+
+	var merged *rawConfig
+	for _, f := range files {
+		frag, err := readConfigFile(f, debug)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeConfigs(merged, frag)
+	}
+	return merged, nil
+}
+
+// fragmentFiles returns the *.yml and *.yaml files directly inside dir,
+// sorted lexically so merge order is deterministic.
+func fragmentFiles(dir string) ([]string, error) {
+	var files []string
+	for _, pattern := range []string{"*.yml", "*.yaml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("error globbing %q: %v", dir, err)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// overlayPath computes the per-environment overlay path for cfgpath, e.g.
+// processes.yml -> processes.production.yml when envOverlayVar=production.
+// ok is false if no environment is configured.
+func overlayPath(cfgpath string) (path string, ok bool) {
+	env := os.Getenv(envOverlayVar)
+	if env == "" {
+		return "", false
+	}
+	ext := filepath.Ext(cfgpath)
+	base := strings.TrimSuffix(cfgpath, ext)
+	return fmt.Sprintf("%s.%s%s", base, env, ext), true
+}
+
+// readConfigFile reads and strictly decodes path into a rawConfig.
+func readConfigFile(path string, debug bool) (*rawConfig, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %q: %v", path, err)
+	}
+	if debug {
+		log.Printf("Config file %q contents:\n%s", path, content)
+	}
+	return decodeConfig(path, string(content))
+}
+
+// mergeConfigs merges src on top of dst: a process_names entry in src whose
+// `id:` matches one already in dst overrides it in place, entries with a
+// new (or no) id are appended.
+func mergeConfigs(dst, src *rawConfig) *rawConfig {
+	if dst == nil {
+		return src
+	}
+	if src == nil {
+		return dst
+	}
+
+	index := make(map[string]int, len(dst.ProcessNames))
+	for i, pn := range dst.ProcessNames {
+		if pn.ID != "" {
+			index[pn.ID] = i
+		}
+	}
+
+	for _, pn := range src.ProcessNames {
+		if pn.ID != "" {
+			if i, found := index[pn.ID]; found {
+				dst.ProcessNames[i] = pn
+				continue
+			}
+			index[pn.ID] = len(dst.ProcessNames)
+		}
+		dst.ProcessNames = append(dst.ProcessNames, pn)
+	}
+
+	return dst
+}
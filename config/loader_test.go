@@ -0,0 +1,93 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMergeConfigsOverridesByID(t *testing.T) {
+	dst := &rawConfig{ProcessNames: []rawProcessName{
+		{ID: "web", Name: "web-v1"},
+		{Name: "anonymous"},
+	}}
+	src := &rawConfig{ProcessNames: []rawProcessName{
+		{ID: "web", Name: "web-v2"},
+		{ID: "db", Name: "db"},
+	}}
+
+	merged := mergeConfigs(dst, src)
+
+	if len(merged.ProcessNames) != 3 {
+		t.Fatalf("got %d entries, want 3: %+v", len(merged.ProcessNames), merged.ProcessNames)
+	}
+	if merged.ProcessNames[0].Name != "web-v2" {
+		t.Errorf("entry with id=web should be overridden in place, got %+v", merged.ProcessNames[0])
+	}
+	if merged.ProcessNames[1].Name != "anonymous" {
+		t.Errorf("unrelated entry should be untouched, got %+v", merged.ProcessNames[1])
+	}
+	if merged.ProcessNames[2].ID != "db" {
+		t.Errorf("new id should be appended, got %+v", merged.ProcessNames[2])
+	}
+}
+
+func TestLoadConfigDirectoryFragments(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "01-base.yml"), `
+process_names:
+  - comm: [sshd]
+    id: sshd
+`)
+	writeFile(t, filepath.Join(dir, "02-override.yaml"), `
+process_names:
+  - comm: [sshd, sshd2]
+    id: sshd
+  - comm: [nginx]
+`)
+
+	raw, err := loadConfig(dir, false)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(raw.ProcessNames) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(raw.ProcessNames), raw.ProcessNames)
+	}
+	if len(raw.ProcessNames[0].Comm) != 2 {
+		t.Errorf("later fragment should override the sshd entry's comm list, got %+v", raw.ProcessNames[0])
+	}
+}
+
+func TestLoadConfigEnvOverlay(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "processes.yml")
+	writeFile(t, base, `
+process_names:
+  - comm: [sshd]
+    id: sshd
+`)
+	writeFile(t, filepath.Join(dir, "processes.production.yml"), `
+process_names:
+  - comm: [sshd, sshd-prod]
+    id: sshd
+`)
+
+	os.Setenv(envOverlayVar, "production")
+	defer os.Unsetenv(envOverlayVar)
+
+	raw, err := loadConfig(base, false)
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if len(raw.ProcessNames) != 1 || len(raw.ProcessNames[0].Comm) != 2 {
+		t.Fatalf("expected overlay to override sshd's comm list, got %+v", raw.ProcessNames)
+	}
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile(%q): %v", path, err)
+	}
+}
@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	common "github.com/ncabatoff/process-exporter"
+)
+
+func (m *usernameMatcher) String() string {
+	var usernames = make([]string, 0, len(m.usernames))
+	for u := range m.usernames {
+		usernames = append(usernames, u)
+	}
+	sort.Strings(usernames)
+	return fmt.Sprintf("usernames: %+v", usernames)
+}
+
+func (m *usernameMatcher) Match(nacl common.ProcAttributes) bool {
+	_, found := m.usernames[nacl.Username]
+	return found
+}
+
+func (c *cgroupMatcher) String() string {
+	return fmt.Sprintf("cgroups: %+v", c.regexes)
+}
+
+func (m *cgroupMatcher) Match(nacl common.ProcAttributes) bool {
+	return m.matchCaptures(nacl, nil)
+}
+
+// matchCaptures is like Match, but if state is non-nil it is filled with
+// the named subexpressions of each matching regex plus the raw cgroup file
+// content, for use as .Matches and .Cgroup in the naming template.
+func (m *cgroupMatcher) matchCaptures(nacl common.ProcAttributes, state *captureState) bool {
+	content, err := readProcFile(nacl.PID, "cgroup")
+	if err != nil {
+		return false
+	}
+	for _, regex := range m.regexes {
+		found := regex.FindStringSubmatch(content)
+		if found == nil {
+			return false
+		}
+		if state == nil {
+			continue
+		}
+		for i, name := range regex.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			state.matches[name] = found[i]
+		}
+	}
+	if state != nil {
+		state.cgroup = content
+	}
+	return true
+}
+
+func (m *envMatcher) String() string {
+	return fmt.Sprintf("env: %+v", m.regexes)
+}
+
+func (m *envMatcher) Match(nacl common.ProcAttributes) bool {
+	return m.matchCaptures(nacl, nil)
+}
+
+// matchCaptures is like Match, but if state is non-nil it is filled with
+// the matched environment variable values, for use as .Env in the naming
+// template.
+func (m *envMatcher) matchCaptures(nacl common.ProcAttributes, state *captureState) bool {
+	environ, err := readProcEnviron(nacl.PID)
+	if err != nil {
+		return false
+	}
+	for name, regex := range m.regexes {
+		value, found := environ[name]
+		if !found || !regex.MatchString(value) {
+			return false
+		}
+		if state == nil {
+			continue
+		}
+		if state.env == nil {
+			state.env = make(map[string]string, len(m.regexes))
+		}
+		state.env[name] = value
+	}
+	return true
+}
+
+func (m *ppidCommMatcher) String() string {
+	var comms = make([]string, 0, len(m.comms))
+	for c := range m.comms {
+		comms = append(comms, c)
+	}
+	sort.Strings(comms)
+	return fmt.Sprintf("ppid_comms: %+v", comms)
+}
+
+func (m *ppidCommMatcher) Match(nacl common.ProcAttributes) bool {
+	ppid, err := readProcPPID(nacl.PID)
+	if err != nil {
+		return false
+	}
+	comm, err := readProcComm(ppid)
+	if err != nil {
+		return false
+	}
+	_, found := m.comms[comm]
+	return found
+}
+
+// readProcFile reads /proc/<pid>/name in full, e.g. the cgroup membership
+// list for pid.
+func readProcFile(pid int, name string) (string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/%s", pid, name))
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// readProcEnviron parses /proc/<pid>/environ's NUL-separated KEY=VALUE
+// entries into a map.
+func readProcEnviron(pid int) (map[string]string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/environ", pid))
+	if err != nil {
+		return nil, err
+	}
+
+	env := make(map[string]string)
+	for _, kv := range strings.Split(string(content), "\x00") {
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		env[parts[0]] = parts[1]
+	}
+	return env, nil
+}
+
+// readProcComm reads /proc/<pid>/comm, the kernel's short name for pid.
+func readProcComm(pid int) (string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(content), "\n"), nil
+}
+
+// readProcPPID extracts the parent PID from /proc/<pid>/stat.
+func readProcPPID(pid int) (int, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return 0, fmt.Errorf("empty /proc/%d/stat", pid)
+	}
+
+	ppid, err := parsePPIDFromStatLine(scanner.Text())
+	if err != nil {
+		return 0, fmt.Errorf("/proc/%d/stat: %v", pid, err)
+	}
+	return ppid, nil
+}
+
+// parsePPIDFromStatLine extracts the ppid field from the content of a
+// /proc/<pid>/stat line. The comm field (2nd, parenthesized) can itself
+// contain spaces or parens, so we anchor on the last ')' and split what
+// follows on whitespace rather than just splitting the whole line.
+func parsePPIDFromStatLine(line string) (int, error) {
+	idx := strings.LastIndex(line, ")")
+	if idx < 0 || idx+2 >= len(line) {
+		return 0, fmt.Errorf("malformed stat line %q", line)
+	}
+	fields := strings.Fields(line[idx+2:])
+	if len(fields) < 2 {
+		return 0, fmt.Errorf("malformed stat line %q", line)
+	}
+
+	ppid, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, fmt.Errorf("bad ppid in stat line %q: %v", line, err)
+	}
+	return ppid, nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import "testing"
+
+func TestParsePPIDFromStatLine(t *testing.T) {
+	cases := []struct {
+		name    string
+		line    string
+		want    int
+		wantErr bool
+	}{
+		{"plain comm", "1234 (bash) S 1 1234 1234 0 -1 4194304 ...", 1, false},
+		{"comm with spaces and parens", "5678 (my (weird) app) S 42 5678 5678 0 -1 4194304 ...", 42, false},
+		{"empty", "", 0, true},
+		{"no closing paren", "1234 bash S 1", 0, true},
+		{"too few fields after comm", "1234 (bash)", 0, true},
+		{"non-numeric ppid", "1234 (bash) S notanumber", 0, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parsePPIDFromStatLine(c.line)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, c.wantErr)
+			}
+			if err == nil && got != c.want {
+				t.Fatalf("ppid = %d, want %d", got, c.want)
+			}
+		})
+	}
+}
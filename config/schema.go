@@ -0,0 +1,76 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+type (
+	// rawConfig is the typed shape of a process-exporter YAML document (or
+	// a merged set of fragments), decoded with yaml.UnmarshalStrict so a
+	// typo'd key (cmdlines:, report_missin:, ...) is rejected up front
+	// instead of silently producing zero matchers.
+	rawConfig struct {
+		ProcessNames []rawProcessName `yaml:"process_names"`
+	}
+
+	// rawProcessName is one process_names list entry: the matcher keys,
+	// embedded via rawMatcherNode, plus metadata that only makes sense at
+	// entry level. ReportMissing is a pointer so presence can be
+	// distinguished from an explicit `false`.
+	rawProcessName struct {
+		Name          string `yaml:"name,omitempty"`
+		ID            string `yaml:"id,omitempty"`
+		ReportMissing *bool  `yaml:"report_missing,omitempty"`
+
+		rawMatcherNode `yaml:",inline"`
+	}
+
+	// rawMatcherNode is the matcher grammar shared by a process_names entry
+	// and any all:/any:/not: node nested inside it.
+	rawMatcherNode struct {
+		Comm          []string          `yaml:"comm,omitempty"`
+		Exe           []string          `yaml:"exe,omitempty"`
+		Cmdline       []string          `yaml:"cmdline,omitempty"`
+		CmdlineSyntax string            `yaml:"cmdline_syntax,omitempty"`
+		Username      []string          `yaml:"username,omitempty"`
+		Cgroup        []string          `yaml:"cgroup,omitempty"`
+		Env           map[string]string `yaml:"env,omitempty"`
+		PPIDComm      []string          `yaml:"ppid_comm,omitempty"`
+		All           []rawMatcherNode  `yaml:"all,omitempty"`
+		Any           []rawMatcherNode  `yaml:"any,omitempty"`
+		Not           *rawMatcherNode   `yaml:"not,omitempty"`
+		Include       []string          `yaml:"include,omitempty"`
+	}
+)
+
+// UnmatchedKeysError reports YAML keys that don't belong anywhere in the
+// process-exporter config schema. Unlike a bare decode error, it names the
+// offending file and, via Issues, the line and key yaml.v2 rejected.
+type UnmatchedKeysError struct {
+	File   string
+	Issues []string
+}
+
+func (e *UnmatchedKeysError) Error() string {
+	return fmt.Sprintf("%s: invalid config keys:\n  %s", e.File, strings.Join(e.Issues, "\n  "))
+}
+
+// decodeConfig strictly decodes content (read from file, used only to
+// label errors) into a rawConfig. Keys that don't match the schema come
+// back as an *UnmatchedKeysError naming file and the line/key yaml.v2
+// identified.
+func decodeConfig(file, content string) (*rawConfig, error) {
+	var cfg rawConfig
+	err := yaml.UnmarshalStrict([]byte(content), &cfg)
+	if err == nil {
+		return &cfg, nil
+	}
+
+	if terr, ok := err.(*yaml.TypeError); ok {
+		return nil, &UnmatchedKeysError{File: file, Issues: terr.Errors}
+	}
+	return nil, fmt.Errorf("error parsing YAML in %q: %v", file, err)
+}
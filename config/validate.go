@@ -0,0 +1,118 @@
+package config
+
+import (
+	"fmt"
+
+	common "github.com/ncabatoff/process-exporter"
+)
+
+// Validate re-parses content (which should already have loaded cleanly via
+// GetConfig/ReadFile) and looks for problems that don't prevent it from
+// loading but are still almost certainly mistakes: two process_names
+// entries sharing the same name: template (their metrics would collide),
+// and entries that can never be reached because an earlier entry with no
+// discriminating matchers already catches every process.
+//
+// This is the check a --check-config CLI flag should run at startup and
+// exit non-zero on; process-exporter's main package doesn't live in this
+// tree, so nothing wires it up yet.
+func Validate(content string) []error {
+	raw, err := decodeConfig("<config>", content)
+	if err != nil {
+		return []error{err}
+	}
+	return validateRaw(raw)
+}
+
+// ValidateFile is Validate for a config path instead of in-memory content:
+// cfgpath is resolved through loadConfig exactly as ReadFile does, so a
+// directory of fragments or a file plus its PROCESS_EXPORTER_ENV overlay is
+// checked as the single merged config it becomes at load time, not just its
+// individual pieces.
+func ValidateFile(cfgpath string) []error {
+	raw, err := loadConfig(cfgpath, false)
+	if err != nil {
+		return []error{err}
+	}
+	return validateRaw(raw)
+}
+
+// validateRaw runs the checks shared by Validate and ValidateFile against an
+// already-loaded rawConfig.
+func validateRaw(raw *rawConfig) []error {
+	cfg, _, err := configFromRaw(raw, false)
+	if err != nil {
+		return []error{err}
+	}
+
+	var errs []error
+	seenTemplates := make(map[string]int)
+	sawCatchAll := false
+	for i, mn := range cfg.MatchNamers.matchers {
+		m, ok := mn.(*matchNamer)
+		if !ok {
+			continue
+		}
+
+		if sawCatchAll {
+			errs = append(errs, fmt.Errorf(
+				"process_names entry %d is unreachable: an earlier entry with no discriminating matchers already matches every process", i))
+		}
+		if isCatchAll(m.andMatcher) {
+			sawCatchAll = true
+		}
+
+		if prev, ok := seenTemplates[m.raw]; ok {
+			errs = append(errs, fmt.Errorf(
+				"process_names entry %d uses the same name template as entry %d (%q); their metrics will collide", i, prev, m.raw))
+		} else {
+			seenTemplates[m.raw] = i
+		}
+	}
+
+	return errs
+}
+
+// isCatchAll reports whether m matches a process with no distinguishing
+// attributes, meaning it would match literally any process.
+//
+// Most leaf matchers can be tested this way directly, by calling Match with
+// a zero-value ProcAttributes. But the matchers that read /proc/<pid> by PID
+// (cgroup, env, ppid_comm) would always fail against PID 0 regardless of
+// their regex, which would make them look "discriminating" even when their
+// pattern is a deliberate catch-all, so those are walked structurally
+// instead: a cgroup regex is tested directly since every running process has
+// cgroup content to match against, while env and ppid_comm each require a
+// specific variable or parent comm to be present and so can never be a true
+// catch-all.
+func isCatchAll(m Matcher) bool {
+	switch mt := m.(type) {
+	case andMatcher:
+		for _, sub := range mt {
+			if !isCatchAll(sub) {
+				return false
+			}
+		}
+		return true
+	case orMatcher:
+		for _, sub := range mt {
+			if isCatchAll(sub) {
+				return true
+			}
+		}
+		return false
+	case notMatcher:
+		return false
+	case *cgroupMatcher:
+		for _, r := range mt.regexes {
+			if !r.MatchString("") {
+				return false
+			}
+		}
+		return true
+	case *envMatcher, *ppidCommMatcher:
+		return false
+	default:
+		return m.Match(common.ProcAttributes{})
+	}
+}
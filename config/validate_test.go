@@ -0,0 +1,82 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateDuplicateNameTemplate(t *testing.T) {
+	cfg := `
+process_names:
+  - comm: [sshd]
+    name: "daemon"
+  - comm: [nginx]
+    name: "daemon"
+`
+	errs := Validate(cfg)
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+}
+
+func TestValidateUnreachableEntry(t *testing.T) {
+	cfg := `
+process_names:
+  - cmdline: [".*"]
+    name: "catch-all"
+  - comm: [sshd]
+    name: "never-reached"
+`
+	errs := Validate(cfg)
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+}
+
+func TestValidateCgroupCatchAllIsDetected(t *testing.T) {
+	cfg := `
+process_names:
+  - cgroup: [".*"]
+    name: "catch-all"
+  - comm: [sshd]
+    name: "never-reached"
+`
+	errs := Validate(cfg)
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+}
+
+func TestValidateEnvIsNeverTreatedAsCatchAll(t *testing.T) {
+	cfg := `
+process_names:
+  - env:
+      FOO: ".*"
+    name: "needs-foo"
+  - comm: [sshd]
+    name: "still-reachable"
+`
+	errs := Validate(cfg)
+	if len(errs) != 0 {
+		t.Fatalf("got %d errors, want 0 (env matcher requires FOO present, it's not a real catch-all): %v", len(errs), errs)
+	}
+}
+
+func TestValidateFileDirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "fragment.yml"), []byte(`
+process_names:
+  - comm: [sshd]
+    name: "daemon"
+  - comm: [nginx]
+    name: "daemon"
+`), 0644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	errs := ValidateFile(dir)
+	if len(errs) != 1 {
+		t.Fatalf("got %d errors, want 1: %v", len(errs), errs)
+	}
+}
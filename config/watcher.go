@@ -0,0 +1,205 @@
+package config
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	reloadSuccessTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "process_exporter",
+		Name:      "config_reload_success_total",
+		Help:      "Number of successful configuration reloads.",
+	})
+	reloadFailureTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "process_exporter",
+		Name:      "config_reload_failure_total",
+		Help:      "Number of failed configuration reloads.",
+	})
+	lastReloadTimestamp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "process_exporter",
+		Name:      "config_last_reload_timestamp_seconds",
+		Help:      "Unix timestamp of the last configuration reload attempt.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(reloadSuccessTotal, reloadFailureTotal, lastReloadTimestamp)
+}
+
+// Watcher holds the currently active Config and keeps it up to date,
+// reloading cfgpath on SIGHUP or (best-effort) on mtime change, and
+// atomically swapping the result in so concurrent readers of Config never
+// see a torn update.
+type Watcher struct {
+	cfgpath string
+	debug   bool
+
+	mu        sync.RWMutex
+	cfg       *Config
+	callbacks []func(*Config)
+
+	sigs chan os.Signal
+	stop chan struct{}
+}
+
+// NewWatcher loads cfgpath and returns a Watcher ready to serve it. Call
+// Watch to start reloading in the background.
+func NewWatcher(cfgpath string, debug bool) (*Watcher, error) {
+	cfg, _, err := ReadFile(cfgpath, debug)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		cfgpath: cfgpath,
+		debug:   debug,
+		cfg:     cfg,
+		sigs:    make(chan os.Signal, 1),
+		stop:    make(chan struct{}),
+	}, nil
+}
+
+// Config returns the currently active Config. Safe for concurrent use with
+// reloads triggered by Watch.
+func (w *Watcher) Config() *Config {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.cfg
+}
+
+// RegisterReloadCallback registers fn to be invoked with the new Config
+// whenever a reload succeeds and HasChanged reports the rules actually
+// differ. Callbacks run synchronously on the watcher goroutine, in
+// registration order, so they should be quick (e.g. drop stale grouper
+// state, pre-seed counters for new matchers).
+func (w *Watcher) RegisterReloadCallback(fn func(*Config)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.callbacks = append(w.callbacks, fn)
+}
+
+// Watch listens for SIGHUP and, if fsnotify is available on this platform,
+// for writes to cfgpath (or, in directory-fragment/env-overlay mode, to
+// whichever file under it actually feeds the merged config), reloading each
+// time. It blocks until Stop is called, so callers should run it in its own
+// goroutine.
+func (w *Watcher) Watch() {
+	signal.Notify(w.sigs, syscall.SIGHUP)
+	defer signal.Stop(w.sigs)
+
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Printf("fsnotify unavailable, reloading on SIGHUP only: %v", err)
+	} else {
+		defer fsw.Close()
+		watchDir := filepath.Dir(w.cfgpath)
+		if info, err := os.Stat(w.cfgpath); err == nil && info.IsDir() {
+			watchDir = w.cfgpath
+		}
+		if err := fsw.Add(watchDir); err != nil {
+			log.Printf("error watching %q for changes: %v", watchDir, err)
+		}
+	}
+
+	for {
+		select {
+		case <-w.sigs:
+			w.reload()
+		case ev, ok := <-fsevents(fsw):
+			if !ok {
+				continue
+			}
+			if w.relevantEvent(ev) {
+				w.reload()
+			}
+		case <-w.stop:
+			return
+		}
+	}
+}
+
+// relevantEvent reports whether ev is a write/create that should trigger a
+// reload: cfgpath itself or its computed env overlay (file mode), or a
+// *.yml/*.yaml fragment directly inside cfgpath (directory mode).
+func (w *Watcher) relevantEvent(ev fsnotify.Event) bool {
+	if ev.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+		return false
+	}
+
+	name := filepath.Clean(ev.Name)
+	if name == filepath.Clean(w.cfgpath) {
+		return true
+	}
+	if overlay, ok := overlayPath(w.cfgpath); ok && name == filepath.Clean(overlay) {
+		return true
+	}
+
+	if filepath.Dir(name) != filepath.Clean(w.cfgpath) {
+		return false
+	}
+	switch filepath.Ext(name) {
+	case ".yml", ".yaml":
+		return true
+	default:
+		return false
+	}
+}
+
+// fsevents returns fsw.Events, or a nil channel (which blocks forever in a
+// select) when fsw is nil because fsnotify setup failed.
+func fsevents(fsw *fsnotify.Watcher) chan fsnotify.Event {
+	if fsw == nil {
+		return nil
+	}
+	return fsw.Events
+}
+
+// Stop terminates Watch.
+func (w *Watcher) Stop() {
+	close(w.stop)
+}
+
+func (w *Watcher) reload() {
+	newcfg, _, err := ReadFile(w.cfgpath, w.debug)
+	if err != nil {
+		log.Printf("error reloading config %q: %v", w.cfgpath, err)
+		reloadFailureTotal.Inc()
+		lastReloadTimestamp.SetToCurrentTime()
+		return
+	}
+
+	w.mu.Lock()
+	changed := HasChanged(w.cfg, newcfg)
+	w.cfg = newcfg
+	callbacks := make([]func(*Config), len(w.callbacks))
+	copy(callbacks, w.callbacks)
+	w.mu.Unlock()
+
+	reloadSuccessTotal.Inc()
+	lastReloadTimestamp.SetToCurrentTime()
+
+	if !changed {
+		return
+	}
+	for _, fn := range callbacks {
+		fn(newcfg)
+	}
+}
+
+// HasChanged reports whether the match rules in old and new differ, so
+// reload callbacks can be skipped when a SIGHUP or mtime event didn't
+// actually change anything (e.g. a comment-only edit, or an unrelated file
+// in the same directory).
+func HasChanged(old, new *Config) bool {
+	if old == nil || new == nil {
+		return old != new
+	}
+	return old.MatchNamers.String() != new.MatchNamers.String()
+}
@@ -0,0 +1,106 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const multiValueConfig = `
+process_names:
+  - comm: [alice, bob, carol, dave, erin, frank, grace]
+    username: [root, daemon, www-data]
+    ppid_comm: [systemd, init, launchd]
+`
+
+func TestHasChangedStableAcrossReloads(t *testing.T) {
+	cfg1, _, err := GetConfig(multiValueConfig, false)
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	cfg2, _, err := GetConfig(multiValueConfig, false)
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+
+	for i := 0; i < 30; i++ {
+		if HasChanged(cfg1, cfg2) {
+			t.Fatalf("iteration %d: HasChanged reported a change between two parses of identical config", i)
+		}
+	}
+}
+
+func TestHasChangedDetectsNameOnlyEdit(t *testing.T) {
+	base := `
+process_names:
+  - comm: [sshd]
+    name: "{{.Comm}}"
+`
+	edited := `
+process_names:
+  - comm: [sshd]
+    name: "ssh-daemon"
+`
+	cfg1, _, err := GetConfig(base, false)
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+	cfg2, _, err := GetConfig(edited, false)
+	if err != nil {
+		t.Fatalf("GetConfig: %v", err)
+	}
+
+	if !HasChanged(cfg1, cfg2) {
+		t.Fatal("expected HasChanged to detect a name-template-only edit")
+	}
+}
+
+func TestRelevantEvent(t *testing.T) {
+	fileWatcher := &Watcher{cfgpath: "/etc/process-exporter/processes.yml"}
+	dirWatcher := &Watcher{cfgpath: "/etc/process-exporter/config.d"}
+
+	cases := []struct {
+		name string
+		w    *Watcher
+		ev   fsnotify.Event
+		want bool
+	}{
+		{
+			"file mode: exact path written",
+			fileWatcher,
+			fsnotify.Event{Name: "/etc/process-exporter/processes.yml", Op: fsnotify.Write},
+			true,
+		},
+		{
+			"file mode: unrelated file in same dir",
+			fileWatcher,
+			fsnotify.Event{Name: "/etc/process-exporter/notes.txt", Op: fsnotify.Write},
+			false,
+		},
+		{
+			"file mode: chmod on the config file doesn't trigger reload",
+			fileWatcher,
+			fsnotify.Event{Name: "/etc/process-exporter/processes.yml", Op: fsnotify.Chmod},
+			false,
+		},
+		{
+			"directory mode: yaml fragment written",
+			dirWatcher,
+			fsnotify.Event{Name: "/etc/process-exporter/config.d/10-web.yml", Op: fsnotify.Write},
+			true,
+		},
+		{
+			"directory mode: non-yaml file in the directory",
+			dirWatcher,
+			fsnotify.Event{Name: "/etc/process-exporter/config.d/README.md", Op: fsnotify.Write},
+			false,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.w.relevantEvent(c.ev); got != c.want {
+				t.Fatalf("relevantEvent = %v, want %v", got, c.want)
+			}
+		})
+	}
+}